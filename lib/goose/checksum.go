@@ -0,0 +1,238 @@
+package goose
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChecksumPolicy controls how goose reacts when the checksum recorded for
+// an applied migration no longer matches the checksum of the migration
+// file on disk.
+type ChecksumPolicy int
+
+const (
+	// ChecksumStrict fails the run on the first mismatch. This is the
+	// default, matching goose's historical (fatal) behavior.
+	ChecksumStrict ChecksumPolicy = iota
+	// ChecksumWarnOnly logs a warning for each mismatch but lets the run
+	// continue.
+	ChecksumWarnOnly
+	// ChecksumOff skips checksum validation entirely.
+	ChecksumOff
+)
+
+// checksumLength is the width of the checksum column (VARCHAR(50)) in every
+// dialect's goose_db_version table except ClickHouse's, whose checksum
+// column is an unbounded String. SHA-256 is truncated to this
+// many hex characters rather than widening the column, so upgrading goose
+// doesn't also require a schema migration of its own bookkeeping table.
+const checksumLength = 50
+
+// checksumStatements computes the stable checksum for a migration's
+// canonicalized statement stream, as produced by splitSQLStatements. Hashing
+// the parsed statements rather than the raw file bytes means a
+// whitespace-only edit, a file saved with different line endings, or an
+// edit to the other direction's section does not change the checksum.
+// Comments are not stripped: a comment that sits before or inside a
+// statement is part of that statement's text, so editing it does change
+// the checksum.
+func checksumStatements(stmts []string) string {
+	var canon strings.Builder
+	for _, stmt := range stmts {
+		canon.WriteString(normalizeStatement(stmt))
+		canon.WriteByte('\n')
+	}
+
+	sum := sha256.Sum256([]byte(canon.String()))
+	hexSum := fmt.Sprintf("%x", sum)
+	if len(hexSum) > checksumLength {
+		hexSum = hexSum[:checksumLength]
+	}
+	return hexSum
+}
+
+// normalizeStatement collapses CRLF to LF and runs of whitespace to single
+// spaces, so that whitespace-only differences between platforms or editors
+// don't register as a checksum mismatch.
+func normalizeStatement(stmt string) string {
+	stmt = strings.ReplaceAll(stmt, "\r\n", "\n")
+	return strings.Join(strings.Fields(stmt), " ")
+}
+
+// legacyChecksum computes goose's original checksum scheme: MD5 over a
+// migration file's raw bytes, with no statement parsing or normalization.
+// The original scheme recorded the hex digest followed by a newline; this
+// returns the digest without it, and checksumMismatch strips that trailing
+// newline from a recorded value before comparing.
+// It exists only so that a goose_db_version row written before the
+// SHA-256-over-canonicalized-statements scheme shipped doesn't immediately
+// report a mismatch for every migration already applied under the old
+// scheme; see checksumMismatch.
+func legacyChecksum(data []byte) string {
+	sum := md5.Sum(data)
+	return fmt.Sprintf("%x", sum)
+}
+
+// checksumMismatch compares got, the checksum already recorded in
+// goose_db_version for a migration, against the checksum of stmts as they
+// exist on disk now, and returns a descriptive error if they differ. got
+// == "" means the migration has never been recorded as applied, or was
+// recorded by a version of goose that predates checksums entirely, and
+// there is nothing yet to compare against. A got that doesn't match the
+// current scheme is tried once more against legacyChecksum of scriptFile's
+// raw bytes before being reported, so upgrading goose doesn't turn every
+// pre-existing migration's recorded checksum into a false mismatch.
+func checksumMismatch(scriptFile string, stmts []string, got string) error {
+	if got == "" {
+		return nil
+	}
+
+	want := checksumStatements(stmts)
+	if want == got {
+		return nil
+	}
+
+	if data, err := ioutil.ReadFile(scriptFile); err == nil && legacyChecksum(data) == strings.TrimSuffix(got, "\n") {
+		return nil
+	}
+
+	return fmt.Errorf("checksum mismatch for %s: recorded %s, on-disk %s", scriptFile, got, want)
+}
+
+// validateChecksum compares the checksum recorded in goose_db_version for
+// migration v against the checksum of scriptFile's parsed statements as
+// they exist on disk now, honoring conf.ChecksumPolicy. It returns a
+// non-nil error on mismatch under ChecksumStrict; under ChecksumWarnOnly it
+// logs and returns nil; under ChecksumOff it does nothing.
+func validateChecksum(conf *DBConf, db *sql.DB, scriptFile string, stmts []string, v int64) error {
+	if conf.ChecksumPolicy == ChecksumOff {
+		return nil
+	}
+
+	got, err := conf.Driver.Dialect.dbCheckSumQuery(db, v)
+	if err != nil {
+		return fmt.Errorf("reading checksum for %s: %v", scriptFile, err)
+	}
+
+	mismatch := checksumMismatch(scriptFile, stmts, got)
+	if mismatch == nil {
+		return nil
+	}
+	if conf.ChecksumPolicy == ChecksumWarnOnly {
+		loggerFor(conf).Printf("WARNING: %v", mismatch)
+		return nil
+	}
+	return mismatch
+}
+
+// appliedVersions resolves which migration versions currently show as
+// applied in goose_db_version, keyed by version. Only the most recent row
+// for each version_id counts: dbVersionQuery orders by id DESC, so a
+// version that was applied and later rolled back still has an older
+// is_applied=true row further down the result set, and that stale row
+// must not override the newer is_applied=false one.
+func appliedVersions(dialect SqlDialect, db *sql.DB) (map[int64]bool, error) {
+	rows, err := dialect.dbVersionQuery(db)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int64]bool{}
+	seen := map[int64]bool{}
+	for rows.Next() {
+		var version int64
+		var isApplied bool
+		if err := rows.Scan(&version, &isApplied); err != nil {
+			return nil, err
+		}
+		if seen[version] {
+			continue
+		}
+		seen[version] = true
+		applied[version] = isApplied
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+// Verify walks every migration currently applied to db, recomputing each
+// one's checksum from the .sql file on disk, and reports every mismatch it
+// finds instead of stopping at the first one like validateChecksum does
+// under ChecksumStrict. It compares checksums directly via
+// checksumMismatch rather than going through validateChecksum, so a
+// mismatch is always reported regardless of conf.ChecksumPolicy: verify's
+// whole purpose is to audit drift independent of the policy that governs
+// automatic Up runs, so ChecksumOff/ChecksumWarnOnly must not cause it to
+// report a clean database that isn't. It's the implementation behind the
+// `goose verify` subcommand.
+func Verify(conf *DBConf, db *sql.DB, migrationsDir string) error {
+	applied, err := appliedVersions(conf.Driver.Dialect, db)
+	if err != nil {
+		return err
+	}
+
+	versions := make([]int64, 0, len(applied))
+	for version := range applied {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+
+	var mismatches []error
+	for _, version := range versions {
+		if !applied[version] {
+			continue
+		}
+		if isGoMigration(version) {
+			// Go migrations have no file on disk to compare against; they
+			// were recorded with an empty checksum at apply time.
+			continue
+		}
+
+		matches, err := filepath.Glob(filepath.Join(migrationsDir, fmt.Sprintf("%d_*.sql", version)))
+		if err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			mismatches = append(mismatches, fmt.Errorf("no .sql file found on disk for applied migration %d", version))
+			continue
+		}
+
+		data, err := ioutil.ReadFile(matches[0])
+		if err != nil {
+			return err
+		}
+		stmts, err := splitSQLStatements(bytes.NewReader(data), true, loggerFor(conf))
+		if err != nil {
+			return fmt.Errorf("parsing %s: %v", matches[0], err)
+		}
+
+		got, err := conf.Driver.Dialect.dbCheckSumQuery(db, version)
+		if err != nil {
+			return fmt.Errorf("reading checksum for %s: %v", matches[0], err)
+		}
+		if err := checksumMismatch(matches[0], stmts, got); err != nil {
+			mismatches = append(mismatches, err)
+		}
+	}
+
+	logger := loggerFor(conf)
+	if len(mismatches) == 0 {
+		logger.Println("verify: all applied migrations match their checksums")
+		return nil
+	}
+
+	for _, mismatch := range mismatches {
+		logger.Println(mismatch)
+	}
+	return fmt.Errorf("verify: %d checksum mismatch(es) found", len(mismatches))
+}
@@ -0,0 +1,278 @@
+package goose
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestChecksumStatementsStable(t *testing.T) {
+	a := checksumStatements([]string{"CREATE TABLE foo (id int);"})
+	b := checksumStatements([]string{"CREATE TABLE foo (id int);"})
+	if a != b {
+		t.Fatalf("checksum of identical statements differs: %q vs %q", a, b)
+	}
+	if len(a) > checksumLength {
+		t.Fatalf("checksum exceeds the VARCHAR(%d) column width: %q", checksumLength, a)
+	}
+
+	c := checksumStatements([]string{"CREATE TABLE bar (id int);"})
+	if a == c {
+		t.Fatalf("checksum of different statements collided: %q", a)
+	}
+}
+
+func TestChecksumStatementsIgnoresWhitespace(t *testing.T) {
+	a := checksumStatements([]string{"CREATE TABLE foo (id int);"})
+	b := checksumStatements([]string{"CREATE   TABLE\r\nfoo (id\tint);"})
+	if a != b {
+		t.Errorf("checksum changed for a whitespace-only difference: %q vs %q", a, b)
+	}
+}
+
+func TestNormalizeStatement(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"SELECT 1;", "SELECT 1;"},
+		{"SELECT   1;\r\n", "SELECT 1;"},
+		{"SELECT\r\n1;", "SELECT 1;"},
+	}
+	for _, c := range cases {
+		if got := normalizeStatement(c.in); got != c.want {
+			t.Errorf("normalizeStatement(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
+
+// newMemSqliteVersionTable opens an in-memory sqlite3 database and creates
+// a goose_db_version table on it, for tests that need a real *sql.DB with
+// the version table already in place.
+func newMemSqliteVersionTable(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	dialect := Sqlite3Dialect{}
+	if _, err := db.Exec(dialect.createVersionTableSql()); err != nil {
+		t.Fatalf("creating goose_db_version: %v", err)
+	}
+	return db
+}
+
+// TestAppliedVersionsDedupesRolledBackMigration is the scenario
+// appliedVersions exists to get right: a migration applied and later
+// rolled back leaves an older is_applied=true row behind its newer
+// is_applied=false row, and only the latter should count.
+func TestAppliedVersionsDedupesRolledBackMigration(t *testing.T) {
+	db := newMemSqliteVersionTable(t)
+	dialect := Sqlite3Dialect{}
+
+	exec := func(version int64, isApplied bool) {
+		if _, err := db.Exec(dialect.insertVersionSql(), version, isApplied, ""); err != nil {
+			t.Fatalf("inserting version row: %v", err)
+		}
+	}
+
+	exec(1, true)  // applied
+	exec(2, true)  // applied, then rolled back below
+	exec(2, false) // the rollback row; this is the one that should win
+	exec(3, true)  // applied
+
+	applied, err := appliedVersions(dialect, db)
+	if err != nil {
+		t.Fatalf("appliedVersions: %v", err)
+	}
+
+	want := map[int64]bool{1: true, 2: false, 3: true}
+	for version, wantApplied := range want {
+		if got, ok := applied[version]; !ok || got != wantApplied {
+			t.Errorf("version %d: got applied=%v (ok=%v), want applied=%v", version, got, ok, wantApplied)
+		}
+	}
+	if len(applied) != len(want) {
+		t.Errorf("got %d versions, want %d: %#v", len(applied), len(want), applied)
+	}
+}
+
+// TestGetCheckSumReturnsMostRecentRow is the checksum-query counterpart to
+// TestAppliedVersionsDedupesRolledBackMigration: a version applied and
+// later rolled back, then re-applied, leaves older rows behind its newest
+// one, and getCheckSum (via dbCheckSumQuery) must return the newest row's
+// checksum, not whatever row the driver happens to scan last.
+func TestGetCheckSumReturnsMostRecentRow(t *testing.T) {
+	db := newMemSqliteVersionTable(t)
+	dialect := Sqlite3Dialect{}
+
+	exec := func(version int64, isApplied bool, checksum string) {
+		if _, err := db.Exec(dialect.insertVersionSql(), version, isApplied, checksum); err != nil {
+			t.Fatalf("inserting version row: %v", err)
+		}
+	}
+
+	exec(1, true, "up-checksum-1")
+	exec(1, false, "down-checksum")
+	exec(1, true, "up-checksum-2") // the current apply; this is the one that should win
+
+	got, err := dialect.dbCheckSumQuery(db, 1)
+	if err != nil {
+		t.Fatalf("dbCheckSumQuery: %v", err)
+	}
+	if got != "up-checksum-2" {
+		t.Errorf("dbCheckSumQuery = %q, want %q (the most recently applied row)", got, "up-checksum-2")
+	}
+}
+
+// TestGetCheckSumNoRowsReturnsEmptyString covers the case validateChecksum
+// relies on: a version that has never been recorded as applied has no
+// goose_db_version row to compare against, so getCheckSum should report an
+// empty string rather than sql.ErrNoRows.
+func TestGetCheckSumNoRowsReturnsEmptyString(t *testing.T) {
+	db := newMemSqliteVersionTable(t)
+	dialect := Sqlite3Dialect{}
+
+	got, err := dialect.dbCheckSumQuery(db, 99)
+	if err != nil {
+		t.Fatalf("dbCheckSumQuery: %v", err)
+	}
+	if got != "" {
+		t.Errorf("dbCheckSumQuery for an unrecorded version = %q, want empty string", got)
+	}
+}
+
+// writeTestMigration writes a single-statement .sql migration to dir under
+// goose's "<version>_*.sql" naming convention, and returns its path.
+func writeTestMigration(t *testing.T, dir string, version int64) string {
+	t.Helper()
+	path := filepath.Join(dir, "1_foo.sql")
+	if version != 1 {
+		path = filepath.Join(dir, fmt.Sprintf("%d_foo.sql", version))
+	}
+	contents := "-- +goose Up\nCREATE TABLE foo (id int);\n-- +goose Down\nDROP TABLE foo;\n"
+	if err := ioutil.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("writing test migration: %v", err)
+	}
+	return path
+}
+
+// newTestDBConf builds a *DBConf for exercising Verify/validateChecksum
+// directly, without a DBDriver wired up from a real goose.yml.
+func newTestDBConf(dialect SqlDialect, policy ChecksumPolicy) *DBConf {
+	return &DBConf{
+		Driver:         DBDriver{Dialect: dialect},
+		ChecksumPolicy: policy,
+		Logger:         nullLogger{},
+	}
+}
+
+// TestVerifyIgnoresChecksumPolicy is the regression test for Verify: under
+// both ChecksumOff and ChecksumWarnOnly, validateChecksum itself returns
+// nil on a mismatch (by design, for automatic Up runs), but Verify must
+// still report it since auditing drift independent of that policy is the
+// whole point of the `goose verify` subcommand.
+func TestVerifyIgnoresChecksumPolicy(t *testing.T) {
+	for _, policy := range []ChecksumPolicy{ChecksumOff, ChecksumWarnOnly, ChecksumStrict} {
+		t.Run(fmt.Sprintf("policy=%d", policy), func(t *testing.T) {
+			dir := t.TempDir()
+			scriptFile := writeTestMigration(t, dir, 1)
+
+			db := newMemSqliteVersionTable(t)
+			dialect := Sqlite3Dialect{}
+			if _, err := db.Exec(dialect.insertVersionSql(), 1, true, "deliberately-wrong-checksum"); err != nil {
+				t.Fatalf("seeding version row: %v", err)
+			}
+
+			conf := newTestDBConf(dialect, policy)
+			err := Verify(conf, db, dir)
+			if err == nil {
+				t.Fatalf("Verify with a seeded wrong checksum returned nil under ChecksumPolicy=%d, want a mismatch error", policy)
+			}
+
+			got, qerr := dialect.dbCheckSumQuery(db, 1)
+			if qerr != nil {
+				t.Fatalf("dbCheckSumQuery: %v", qerr)
+			}
+			stmts, perr := splitSQLStatements(mustOpen(t, scriptFile), true, nullLogger{})
+			if perr != nil {
+				t.Fatalf("splitSQLStatements: %v", perr)
+			}
+			if checksumMismatch(scriptFile, stmts, got) == nil {
+				t.Fatal("test setup is wrong: seeded checksum does not actually mismatch")
+			}
+		})
+	}
+}
+
+// TestVerifyPassesWhenChecksumsMatch is TestVerifyIgnoresChecksumPolicy's
+// counterpart: a migration recorded with its real, current checksum must
+// not be reported, regardless of policy.
+func TestVerifyPassesWhenChecksumsMatch(t *testing.T) {
+	dir := t.TempDir()
+	scriptFile := writeTestMigration(t, dir, 1)
+
+	stmts, err := splitSQLStatements(mustOpen(t, scriptFile), true, nullLogger{})
+	if err != nil {
+		t.Fatalf("splitSQLStatements: %v", err)
+	}
+	checksum := checksumStatements(stmts)
+
+	db := newMemSqliteVersionTable(t)
+	dialect := Sqlite3Dialect{}
+	if _, err := db.Exec(dialect.insertVersionSql(), 1, true, checksum); err != nil {
+		t.Fatalf("seeding version row: %v", err)
+	}
+
+	conf := newTestDBConf(dialect, ChecksumOff)
+	if err := Verify(conf, db, dir); err != nil {
+		t.Errorf("Verify with a matching checksum = %v, want nil", err)
+	}
+}
+
+// TestChecksumMismatchAcceptsLegacyMD5Checksum covers the upgrade path:
+// a goose_db_version row written under the pre-chunk0-4 scheme (MD5 over
+// the migration file's raw bytes) must not be reported as a mismatch by
+// the new SHA-256-over-canonicalized-statements scheme just because goose
+// was upgraded; nothing about the migration itself changed.
+func TestChecksumMismatchAcceptsLegacyMD5Checksum(t *testing.T) {
+	dir := t.TempDir()
+	scriptFile := writeTestMigration(t, dir, 1)
+
+	data, err := ioutil.ReadFile(scriptFile)
+	if err != nil {
+		t.Fatalf("reading test migration: %v", err)
+	}
+	legacy := legacyChecksum(data)
+
+	stmts, err := splitSQLStatements(mustOpen(t, scriptFile), true, nullLogger{})
+	if err != nil {
+		t.Fatalf("splitSQLStatements: %v", err)
+	}
+	if legacy == checksumStatements(stmts) {
+		t.Fatal("test setup is wrong: legacy and current checksums collided")
+	}
+
+	if err := checksumMismatch(scriptFile, stmts, legacy); err != nil {
+		t.Errorf("checksumMismatch against a legacy MD5 checksum = %v, want nil", err)
+	}
+}
+
+// mustOpen opens path for reading, failing the test on error. Tests use it
+// to get an io.Reader for splitSQLStatements without juggling *os.File
+// cleanup inline.
+func mustOpen(t *testing.T, path string) *os.File {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("os.Open(%q): %v", path, err)
+	}
+	t.Cleanup(func() { f.Close() })
+	return f
+}
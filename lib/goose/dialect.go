@@ -3,11 +3,21 @@ package goose
 import (
 	"database/sql"
 	"fmt"
-	"log"
+	"strings"
 
 	"github.com/mattn/go-sqlite3"
 )
 
+// Tx is the subset of *sql.Tx that goose needs to run a migration. Engines
+// with real transactional DDL can hand back a plain *sql.Tx, which already
+// satisfies this interface; engines without transactions (e.g. ClickHouse)
+// can instead return a no-op wrapper around *sql.DB.
+type Tx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Commit() error
+	Rollback() error
+}
+
 // SqlDialect abstracts the details of specific SQL dialects
 // for goose's few SQL specific statements
 type SqlDialect interface {
@@ -15,20 +25,73 @@ type SqlDialect interface {
 	insertVersionSql() string      // sql string to insert the initial version table row
 	dbVersionQuery(db *sql.DB) (*sql.Rows, error)
 	dbCheckSumQuery(db *sql.DB, version int64) (string, error)
+	beginTx(db *sql.DB) (Tx, error) // start the transaction (or transaction-like wrapper) a migration runs in
+}
+
+var dialectRegistry = map[string]SqlDialect{}
+
+func init() {
+	RegisterDialect("postgres", &PostgresDialect{})
+	RegisterDialect("mysql", &MySqlDialect{})
+	RegisterDialect("sqlite3", &Sqlite3Dialect{})
+	RegisterDialect("mssql", &MssqlDialect{})
+	RegisterDialect("cockroach", &CockroachDialect{})
+	RegisterDialect("clickhouse", &ClickhouseDialect{})
+}
+
+// RegisterDialect makes a SqlDialect available under the given driver name,
+// so that third-party drivers (or additional built-ins) can be plugged in
+// without modifying dialectByName itself.
+func RegisterDialect(name string, d SqlDialect) {
+	dialectRegistry[name] = d
 }
 
 // drivers that we don't know about can ask for a dialect by name
 func dialectByName(d string) SqlDialect {
-	switch d {
-	case "postgres":
-		return &PostgresDialect{}
-	case "mysql":
-		return &MySqlDialect{}
-	case "sqlite3":
-		return &Sqlite3Dialect{}
+	return dialectRegistry[d]
+}
+
+// beginTx starts a plain database/sql transaction, which already satisfies
+// the Tx interface. Dialects backed by an engine with real transactional
+// DDL should embed or call this as their beginTx implementation.
+func beginTx(db *sql.DB) (Tx, error) {
+	return db.Begin()
+}
+
+// RetryableDialect is implemented by dialects on which a migration's
+// Exec-then-Commit sequence can fail with a transient error that is safe to
+// retry from a fresh transaction, such as CockroachDB's serialization
+// restart (SQLSTATE 40001). runSQLMigration and runGoMigration check for it
+// via withRetry so that only those dialects pay for the retry loop.
+type RetryableDialect interface {
+	shouldRetry(err error) bool
+}
+
+// maxTransientRetries bounds how many times in all withRetry runs attempt
+// (the first run included) while a RetryableDialect keeps reporting err as
+// transient, before giving up and returning that error to the caller.
+const maxTransientRetries = 3
+
+// withRetry runs attempt once and returns its result, unless dialect is a
+// RetryableDialect that reports the error as transient, in which case
+// attempt is re-run from scratch (a fresh beginTx through the
+// Exec-then-Commit sequence, not just the failed statement) until it has
+// run maxTransientRetries times in all. Dialects that don't implement RetryableDialect
+// run attempt exactly once, the same as before this existed.
+func withRetry(dialect SqlDialect, attempt func() error) error {
+	retryable, ok := dialect.(RetryableDialect)
+	if !ok {
+		return attempt()
 	}
 
-	return nil
+	var err error
+	for i := 0; i < maxTransientRetries; i++ {
+		err = attempt()
+		if err == nil || !retryable.shouldRetry(err) {
+			return err
+		}
+	}
+	return err
 }
 
 ////////////////////////////
@@ -68,6 +131,10 @@ func (pg PostgresDialect) dbCheckSumQuery(db *sql.DB, version int64) (string, er
 	return getCheckSum(db, version)
 }
 
+func (pg PostgresDialect) beginTx(db *sql.DB) (Tx, error) {
+	return beginTx(db)
+}
+
 ////////////////////////////
 // MySQL
 ////////////////////////////
@@ -106,27 +173,25 @@ func (m MySqlDialect) dbCheckSumQuery(db *sql.DB, version int64) (string, error)
 	return getCheckSum(db, version)
 }
 
+func (m MySqlDialect) beginTx(db *sql.DB) (Tx, error) {
+	return beginTx(db)
+}
+
+// getCheckSum returns the checksum recorded for version's most recent
+// goose_db_version row. A version can have more than one row (applied,
+// then rolled back), same as appliedVersions has to account for, and the
+// most recently inserted row is the one that reflects the migration's
+// current state; an older row from an earlier apply/rollback cycle must
+// not override it.
 func getCheckSum(db *sql.DB, version int64) (string, error) {
 	var checksum string
-	query := fmt.Sprintf("SELECT checksum from goose_db_version WHERE version_id = %d", version)
-	log.Println("query to execute:", query)
-	rows, err := db.Query(query)
-	log.Println("row retrived from db:", rows)
-
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer rows.Close()
-	for rows.Next() {
-		err := rows.Scan(&checksum)
-		if err != nil {
-			log.Fatal(err)
-		}
-		log.Println(checksum)
+	query := fmt.Sprintf("SELECT checksum from goose_db_version WHERE version_id = %d ORDER BY id DESC LIMIT 1", version)
+	err := db.QueryRow(query).Scan(&checksum)
+	if err == sql.ErrNoRows {
+		return "", nil
 	}
-	err = rows.Err()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
 
 	return checksum, nil
@@ -143,25 +208,270 @@ func (m Sqlite3Dialect) createVersionTableSql() string {
                 id INTEGER PRIMARY KEY AUTOINCREMENT,
                 version_id INTEGER NOT NULL,
                 is_applied INTEGER NOT NULL,
+                checksum VARCHAR(50) NOT NULL DEFAULT '',
                 tstamp TIMESTAMP DEFAULT (datetime('now'))
             );`
 }
 
 func (m Sqlite3Dialect) insertVersionSql() string {
-	return "INSERT INTO goose_db_version (version_id, is_applied) VALUES (?, ?);"
+	return "INSERT INTO goose_db_version (version_id, is_applied, checksum) VALUES (?, ?, ?);"
 }
 
 func (m Sqlite3Dialect) dbVersionQuery(db *sql.DB) (*sql.Rows, error) {
+	// Upgrade a goose_db_version created by a pre-checksum version of
+	// goose before running the SELECT below, not after: EnsureSqlite3ChecksumColumn
+	// issues its own queries against db, and on a :memory: DSN a second
+	// pooled connection is a brand-new, empty database, so it must not run
+	// while this dialect's SELECT still has rows checked out on a
+	// connection of its own. Every version check runs this, with no
+	// separate step for a caller to remember.
+	if err := EnsureSqlite3ChecksumColumn(db); err != nil {
+		return nil, err
+	}
+
 	rows, err := db.Query("SELECT version_id, is_applied from goose_db_version ORDER BY id DESC")
 
 	switch err.(type) {
 	case sqlite3.Error:
 		return nil, ErrTableDoesNotExist
 	}
-	return rows, err
+	if err != nil {
+		return rows, err
+	}
+
+	return rows, nil
 }
 
 func (m Sqlite3Dialect) dbCheckSumQuery(db *sql.DB, version int64) (string, error) {
-	panic("Check sum column is not present in goose_db_version table, Hence can't retrive it")
-	return "", nil
+	return getCheckSum(db, version)
+}
+
+func (m Sqlite3Dialect) beginTx(db *sql.DB) (Tx, error) {
+	return beginTx(db)
+}
+
+// EnsureSqlite3ChecksumColumn upgrades a goose_db_version table created by
+// an older version of goose, which has no checksum column, by adding it and
+// backfilling existing rows with an empty checksum. It is idempotent and
+// safe to call on every startup: a database that already has the column is
+// left untouched. If goose_db_version doesn't exist at all yet, there is
+// nothing to upgrade, so it does nothing and leaves reporting that to the
+// caller's own query against the table. Sqlite3Dialect.dbVersionQuery calls
+// this automatically, so existing sqlite3 databases upgrade cleanly without
+// any action from the caller.
+func EnsureSqlite3ChecksumColumn(db *sql.DB) error {
+	var name string
+	err := db.QueryRow("SELECT name FROM sqlite_master WHERE type = 'table' AND name = 'goose_db_version'").Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	rows, err := db.Query("PRAGMA table_info(goose_db_version)")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	hasChecksum := false
+	for rows.Next() {
+		var cid int
+		var name, ctype string
+		var notNull, pk int
+		var dfltValue interface{}
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &dfltValue, &pk); err != nil {
+			return err
+		}
+		if name == "checksum" {
+			hasChecksum = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if hasChecksum {
+		return nil
+	}
+
+	if _, err := db.Exec("ALTER TABLE goose_db_version ADD COLUMN checksum VARCHAR(50) NOT NULL DEFAULT ''"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("UPDATE goose_db_version SET checksum = '' WHERE checksum IS NULL"); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+////////////////////////////
+// MSSQL
+////////////////////////////
+
+type MssqlDialect struct{}
+
+func (m MssqlDialect) createVersionTableSql() string {
+	return `CREATE TABLE goose_db_version (
+                id INT IDENTITY(1,1) NOT NULL,
+                version_id BIGINT NOT NULL,
+                is_applied BIT NOT NULL,
+                checksum VARCHAR(50) NOT NULL,
+                tstamp DATETIME NULL DEFAULT (getdate()),
+                PRIMARY KEY(id)
+            );`
+}
+
+func (m MssqlDialect) insertVersionSql() string {
+	return "INSERT INTO goose_db_version (version_id, is_applied, checksum) VALUES (@p1, @p2, @p3);"
+}
+
+func (m MssqlDialect) dbVersionQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query("SELECT version_id, is_applied from goose_db_version ORDER BY id DESC")
+
+	// XXX: check for mssql specific error indicating the table doesn't exist.
+	// for now, assume any error is because the table doesn't exist,
+	// in which case we'll try to create it.
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+
+	return rows, err
+}
+
+func (m MssqlDialect) dbCheckSumQuery(db *sql.DB, version int64) (string, error) {
+	return getCheckSum(db, version)
+}
+
+func (m MssqlDialect) beginTx(db *sql.DB) (Tx, error) {
+	return beginTx(db)
+}
+
+////////////////////////////
+// CockroachDB
+////////////////////////////
+
+// CockroachDialect speaks the Postgres wire protocol and mostly behaves
+// like PostgresDialect, but uses SERIAL for the primary key (CockroachDB's
+// SERIAL is a distributed-friendly sequence rather than Postgres's), and
+// implements RetryableDialect so a contended migration is retried instead
+// of failing outright; see shouldRetry.
+type CockroachDialect struct{}
+
+func (c CockroachDialect) createVersionTableSql() string {
+	return `CREATE TABLE goose_db_version (
+                id SERIAL NOT NULL,
+                version_id BIGINT NOT NULL,
+                is_applied BOOLEAN NOT NULL,
+                checksum VARCHAR (50) NOT NULL,
+                tstamp TIMESTAMP NULL DEFAULT now(),
+                PRIMARY KEY(id)
+            );`
+}
+
+func (c CockroachDialect) insertVersionSql() string {
+	return "INSERT INTO goose_db_version (version_id, is_applied, checksum) VALUES ($1, $2, $3);"
+}
+
+func (c CockroachDialect) dbVersionQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query("SELECT version_id, is_applied from goose_db_version ORDER BY id DESC")
+
+	// XXX: check for cockroach specific error indicating the table doesn't exist.
+	// for now, assume any error is because the table doesn't exist,
+	// in which case we'll try to create it.
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+
+	return rows, err
+}
+
+func (c CockroachDialect) dbCheckSumQuery(db *sql.DB, version int64) (string, error) {
+	return getCheckSum(db, version)
+}
+
+// beginTx starts a plain transaction, the same as Postgres. CockroachDB's
+// serialization-restart error (SQLSTATE 40001) surfaces later, from a
+// contended Exec or Commit, not from BEGIN itself, so there's nothing to
+// retry here; see shouldRetry, which is what actually drives the retry via
+// withRetry wrapping the whole statement-exec-then-commit sequence in
+// runSQLMigration/runGoMigration.
+func (c CockroachDialect) beginTx(db *sql.DB) (Tx, error) {
+	return beginTx(db)
+}
+
+// shouldRetry reports whether err looks like CockroachDB's serialization
+// restart error (SQLSTATE 40001). There's no CockroachDB client library
+// imported here to type-assert a structured error code against, so this
+// matches on the SQLSTATE CockroachDB includes in the error text it
+// returns for that condition.
+func (c CockroachDialect) shouldRetry(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "40001")
+}
+
+////////////////////////////
+// ClickHouse
+////////////////////////////
+
+// ClickhouseDialect targets ClickHouse, which has no real multi-statement
+// transactions. The version table uses ReplacingMergeTree so that repeated
+// inserts for the same version_id collapse down to the latest row on
+// background merges, approximating the update-in-place semantics the other
+// dialects get from a real transaction.
+type ClickhouseDialect struct{}
+
+func (ch ClickhouseDialect) createVersionTableSql() string {
+	return `CREATE TABLE goose_db_version (
+                id UInt64,
+                version_id Int64,
+                is_applied UInt8,
+                checksum String,
+                tstamp DateTime DEFAULT now()
+            ) ENGINE = ReplacingMergeTree(tstamp)
+            ORDER BY (version_id);`
+}
+
+func (ch ClickhouseDialect) insertVersionSql() string {
+	return "INSERT INTO goose_db_version (version_id, is_applied, checksum) VALUES (?, ?, ?);"
+}
+
+func (ch ClickhouseDialect) dbVersionQuery(db *sql.DB) (*sql.Rows, error) {
+	rows, err := db.Query("SELECT version_id, is_applied from goose_db_version ORDER BY id DESC")
+
+	// XXX: check for clickhouse specific error indicating the table doesn't exist.
+	// for now, assume any error is because the table doesn't exist,
+	// in which case we'll try to create it.
+	if err != nil {
+		return nil, ErrTableDoesNotExist
+	}
+
+	return rows, err
+}
+
+func (ch ClickhouseDialect) dbCheckSumQuery(db *sql.DB, version int64) (string, error) {
+	return getCheckSum(db, version)
+}
+
+func (ch ClickhouseDialect) beginTx(db *sql.DB) (Tx, error) {
+	return &noopTx{db: db}, nil
+}
+
+// noopTx wraps a *sql.DB so non-transactional engines like ClickHouse can
+// be driven through the same runSQLMigration codepath as transactional
+// ones: Exec runs directly against the database, and Commit/Rollback are
+// no-ops since there is no transaction to end.
+type noopTx struct {
+	db *sql.DB
+}
+
+func (t *noopTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.db.Exec(query, args...)
+}
+
+func (t *noopTx) Commit() error {
+	return nil
+}
+
+func (t *noopTx) Rollback() error {
+	return nil
 }
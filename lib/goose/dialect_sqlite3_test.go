@@ -0,0 +1,136 @@
+package goose
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// oldSchemaSqliteVersionTable creates a goose_db_version table as a
+// pre-checksum version of goose would have, with no checksum column, so
+// EnsureSqlite3ChecksumColumn has something to upgrade.
+func oldSchemaSqliteVersionTable(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	if _, err := db.Exec(`CREATE TABLE goose_db_version (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		version_id INTEGER NOT NULL,
+		is_applied INTEGER NOT NULL,
+		tstamp TIMESTAMP DEFAULT (datetime('now'))
+	);`); err != nil {
+		t.Fatalf("creating old-schema goose_db_version: %v", err)
+	}
+	if _, err := db.Exec("INSERT INTO goose_db_version (version_id, is_applied) VALUES (1, 1)"); err != nil {
+		t.Fatalf("seeding old-schema row: %v", err)
+	}
+	return db
+}
+
+func hasChecksumColumn(t *testing.T, db *sql.DB) bool {
+	t.Helper()
+	rows, err := db.Query("PRAGMA table_info(goose_db_version)")
+	if err != nil {
+		t.Fatalf("PRAGMA table_info: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid int
+		var name, ctype string
+		var notNull, pk int
+		var dfltValue interface{}
+		if err := rows.Scan(&cid, &name, &ctype, &notNull, &dfltValue, &pk); err != nil {
+			t.Fatalf("scanning table_info row: %v", err)
+		}
+		if name == "checksum" {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEnsureSqlite3ChecksumColumnUpgradesOldSchema(t *testing.T) {
+	db := oldSchemaSqliteVersionTable(t)
+
+	if hasChecksumColumn(t, db) {
+		t.Fatal("test setup is wrong: old-schema table already has a checksum column")
+	}
+
+	if err := EnsureSqlite3ChecksumColumn(db); err != nil {
+		t.Fatalf("EnsureSqlite3ChecksumColumn: %v", err)
+	}
+	if !hasChecksumColumn(t, db) {
+		t.Fatal("EnsureSqlite3ChecksumColumn did not add the checksum column")
+	}
+
+	var checksum string
+	if err := db.QueryRow("SELECT checksum FROM goose_db_version WHERE version_id = 1").Scan(&checksum); err != nil {
+		t.Fatalf("reading backfilled checksum: %v", err)
+	}
+	if checksum != "" {
+		t.Errorf("backfilled checksum = %q, want empty string", checksum)
+	}
+}
+
+func TestEnsureSqlite3ChecksumColumnIsIdempotent(t *testing.T) {
+	db := oldSchemaSqliteVersionTable(t)
+
+	if err := EnsureSqlite3ChecksumColumn(db); err != nil {
+		t.Fatalf("first EnsureSqlite3ChecksumColumn call: %v", err)
+	}
+	if err := EnsureSqlite3ChecksumColumn(db); err != nil {
+		t.Fatalf("second EnsureSqlite3ChecksumColumn call (should be a no-op): %v", err)
+	}
+}
+
+func TestEnsureSqlite3ChecksumColumnLeavesNewSchemaAlone(t *testing.T) {
+	db := newMemSqliteVersionTable(t)
+
+	if !hasChecksumColumn(t, db) {
+		t.Fatal("test setup is wrong: new-schema table has no checksum column")
+	}
+	if err := EnsureSqlite3ChecksumColumn(db); err != nil {
+		t.Fatalf("EnsureSqlite3ChecksumColumn on an already-current schema: %v", err)
+	}
+}
+
+// TestEnsureSqlite3ChecksumColumnNoTableIsNoop covers the case
+// Sqlite3Dialect.dbVersionQuery relies on: goose_db_version doesn't exist
+// at all yet (a brand-new database), so there's nothing to upgrade.
+// EnsureSqlite3ChecksumColumn must leave reporting that to dbVersionQuery's
+// own SELECT rather than erroring out itself.
+func TestEnsureSqlite3ChecksumColumnNoTableIsNoop(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+
+	if err := EnsureSqlite3ChecksumColumn(db); err != nil {
+		t.Fatalf("EnsureSqlite3ChecksumColumn with no goose_db_version table: %v", err)
+	}
+}
+
+// TestSqlite3DialectDbVersionQueryOnFreshTable guards against a regression
+// where EnsureSqlite3ChecksumColumn ran after dbVersionQuery's SELECT while
+// that SELECT's *sql.Rows were still open: on a :memory: DSN, the pool
+// hands the nested PRAGMA/ALTER calls a second, brand-new, empty
+// connection, which has no goose_db_version table at all and fails with
+// "no such table: goose_db_version" even though the database is already
+// fully migrated.
+func TestSqlite3DialectDbVersionQueryOnFreshTable(t *testing.T) {
+	db := newMemSqliteVersionTable(t)
+	dialect := Sqlite3Dialect{}
+
+	rows, err := dialect.dbVersionQuery(db)
+	if err != nil {
+		t.Fatalf("dbVersionQuery on a freshly created table: %v", err)
+	}
+	rows.Close()
+}
@@ -0,0 +1,183 @@
+package goose
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestDialectByNameReturnsRegisteredDialects(t *testing.T) {
+	cases := []struct {
+		name string
+		want SqlDialect
+	}{
+		{"postgres", &PostgresDialect{}},
+		{"mysql", &MySqlDialect{}},
+		{"sqlite3", &Sqlite3Dialect{}},
+		{"mssql", &MssqlDialect{}},
+		{"cockroach", &CockroachDialect{}},
+		{"clickhouse", &ClickhouseDialect{}},
+	}
+	for _, c := range cases {
+		got := dialectByName(c.name)
+		if got == nil {
+			t.Errorf("dialectByName(%q) = nil, want a %T", c.name, c.want)
+			continue
+		}
+		if fmt.Sprintf("%T", got) != fmt.Sprintf("%T", c.want) {
+			t.Errorf("dialectByName(%q) = %T, want %T", c.name, got, c.want)
+		}
+	}
+
+	if got := dialectByName("no-such-driver"); got != nil {
+		t.Errorf("dialectByName for an unregistered name = %T, want nil", got)
+	}
+}
+
+// TestNewDialectsProduceVersionTableSql is a smoke test for the three
+// dialects added alongside the pluggable registry: it doesn't have a
+// driver to run these against, but it catches an outright typo or a
+// missing column in the DDL/DML string literals.
+func TestNewDialectsProduceVersionTableSql(t *testing.T) {
+	cases := []struct {
+		name    string
+		dialect SqlDialect
+		want    []string
+	}{
+		{"mssql", MssqlDialect{}, []string{"goose_db_version", "version_id", "is_applied", "checksum"}},
+		{"cockroach", CockroachDialect{}, []string{"goose_db_version", "version_id", "is_applied", "checksum"}},
+		{"clickhouse", ClickhouseDialect{}, []string{"goose_db_version", "version_id", "is_applied", "checksum", "ReplacingMergeTree"}},
+	}
+	for _, c := range cases {
+		ddl := c.dialect.createVersionTableSql()
+		for _, want := range c.want {
+			if !strings.Contains(ddl, want) {
+				t.Errorf("%s createVersionTableSql() missing %q:\n%s", c.name, want, ddl)
+			}
+		}
+		if dml := c.dialect.insertVersionSql(); !strings.Contains(dml, "goose_db_version") {
+			t.Errorf("%s insertVersionSql() missing goose_db_version: %s", c.name, dml)
+		}
+	}
+}
+
+// TestNoopTxDelegatesToDB exercises ClickhouseDialect's transaction-less
+// Tx: Exec must run directly against the database (there's no real
+// transaction to buffer it in), and Commit/Rollback must be no-ops since
+// there's nothing to end.
+func TestNoopTxDelegatesToDB(t *testing.T) {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("CREATE TABLE foo (id int)"); err != nil {
+		t.Fatalf("creating table: %v", err)
+	}
+
+	txn := &noopTx{db: db}
+	if _, err := txn.Exec("INSERT INTO foo (id) VALUES (1)"); err != nil {
+		t.Fatalf("noopTx.Exec: %v", err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Errorf("noopTx.Commit() = %v, want nil", err)
+	}
+	if err := txn.Rollback(); err != nil {
+		t.Errorf("noopTx.Rollback() = %v, want nil", err)
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM foo").Scan(&count); err != nil {
+		t.Fatalf("querying row count: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("got %d rows, want 1 (noopTx.Exec should have run directly against db)", count)
+	}
+}
+
+func TestCockroachDialectShouldRetry(t *testing.T) {
+	c := CockroachDialect{}
+
+	if c.shouldRetry(nil) {
+		t.Error("shouldRetry(nil) = true, want false")
+	}
+	if !c.shouldRetry(fmt.Errorf("restart transaction: TransactionRetryWithProtoRefreshError: ... (SQLSTATE 40001)")) {
+		t.Error("shouldRetry on a 40001 serialization-restart error = false, want true")
+	}
+	if c.shouldRetry(fmt.Errorf("syntax error at or near %q", "foo")) {
+		t.Error("shouldRetry on an unrelated error = true, want false")
+	}
+}
+
+// TestWithRetryRetriesOnlyForRetryableDialects exercises withRetry's two
+// cases: a dialect that doesn't implement RetryableDialect (e.g. postgres)
+// runs attempt exactly once no matter what it returns, while one that does
+// (CockroachDialect) keeps retrying until attempt stops reporting the
+// error as transient, or maxTransientRetries is reached.
+func TestWithRetryRetriesOnlyForRetryableDialects(t *testing.T) {
+	retryableErr := fmt.Errorf("restart transaction: (SQLSTATE 40001)")
+
+	t.Run("non-retryable dialect runs attempt once", func(t *testing.T) {
+		calls := 0
+		err := withRetry(PostgresDialect{}, func() error {
+			calls++
+			return retryableErr
+		})
+		if calls != 1 {
+			t.Errorf("attempt ran %d times, want 1", calls)
+		}
+		if err != retryableErr {
+			t.Errorf("withRetry returned %v, want %v", err, retryableErr)
+		}
+	})
+
+	t.Run("retryable dialect retries until success", func(t *testing.T) {
+		calls := 0
+		err := withRetry(CockroachDialect{}, func() error {
+			calls++
+			if calls < 2 {
+				return retryableErr
+			}
+			return nil
+		})
+		if err != nil {
+			t.Errorf("withRetry = %v, want nil", err)
+		}
+		if calls != 2 {
+			t.Errorf("attempt ran %d times, want 2", calls)
+		}
+	})
+
+	t.Run("retryable dialect gives up after maxTransientRetries", func(t *testing.T) {
+		calls := 0
+		err := withRetry(CockroachDialect{}, func() error {
+			calls++
+			return retryableErr
+		})
+		if calls != maxTransientRetries {
+			t.Errorf("attempt ran %d times, want %d", calls, maxTransientRetries)
+		}
+		if err != retryableErr {
+			t.Errorf("withRetry returned %v, want %v", err, retryableErr)
+		}
+	})
+
+	t.Run("retryable dialect does not retry a non-transient error", func(t *testing.T) {
+		calls := 0
+		nonTransient := fmt.Errorf("syntax error")
+		err := withRetry(CockroachDialect{}, func() error {
+			calls++
+			return nonTransient
+		})
+		if calls != 1 {
+			t.Errorf("attempt ran %d times, want 1", calls)
+		}
+		if err != nonTransient {
+			t.Errorf("withRetry returned %v, want %v", err, nonTransient)
+		}
+	})
+}
@@ -0,0 +1,25 @@
+package goose
+
+import "log"
+
+// Logger is the logging sink goose writes warnings and progress messages
+// to. It is satisfied by the standard library's *log.Logger, so embedding
+// goose in a larger application (which likely already has its own logger)
+// requires no adapter beyond this interface.
+type Logger interface {
+	Printf(format string, v ...interface{})
+	Println(v ...interface{})
+}
+
+// DefaultLogger is used whenever a DBConf does not set its own Logger. It
+// writes to the standard log package, matching goose's historical output.
+var DefaultLogger Logger = log.New(log.Writer(), log.Prefix(), log.Flags())
+
+// loggerFor returns conf's configured Logger, falling back to
+// DefaultLogger so callers never need to nil-check before logging.
+func loggerFor(conf *DBConf) Logger {
+	if conf != nil && conf.Logger != nil {
+		return conf.Logger
+	}
+	return DefaultLogger
+}
@@ -0,0 +1,132 @@
+package goose
+
+import (
+	"database/sql"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// RunMigrationsOnDb is the migration walker: it resolves db's current
+// version, finds every .sql file in migrationsDir together with every Go
+// migration registered via RegisterMigration, and applies (target >
+// current) or rolls back (target < current) everything between the two,
+// in version order, dispatching each one through runMigration so Go and
+// SQL migrations share the same transactional FinalizeMigration flow.
+func RunMigrationsOnDb(conf *DBConf, migrationsDir string, target int64, db *sql.DB) error {
+	applied, err := appliedVersions(conf.Driver.Dialect, db)
+	if err != nil {
+		return err
+	}
+
+	var current int64
+	for version, isApplied := range applied {
+		if isApplied && version > current {
+			current = version
+		}
+	}
+
+	if current == target {
+		return nil
+	}
+	direction := target > current
+
+	sqlMigrations, err := collectSQLMigrations(migrationsDir, current, target, direction)
+	if err != nil {
+		return err
+	}
+
+	versions := mergeMigrationVersions(sqlMigrations, pendingGoMigrationVersions(current, target), direction)
+
+	for _, v := range versions {
+		// sqlMigrations[v] is "" for a version that only exists as a Go
+		// migration; runMigration ignores scriptFile in that case.
+		if err := runMigration(conf, db, sqlMigrations[v], v, direction); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// collectSQLMigrations finds every "<version>_*.sql" file directly inside
+// dir whose version falls between current and target: above current and
+// up to and including target for an Up run, at or below current and above
+// target for a Down run. It uses the same "<version>_*.sql" naming convention Verify
+// already relies on. It returns the matches keyed by version.
+func collectSQLMigrations(dir string, current, target int64, direction bool) (map[int64]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := map[int64]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+		version, ok := versionFromFilename(entry.Name())
+		if !ok {
+			continue
+		}
+
+		var inRange bool
+		if direction {
+			inRange = version > current && version <= target
+		} else {
+			inRange = version <= current && version > target
+		}
+		if !inRange {
+			continue
+		}
+		migrations[version] = filepath.Join(dir, entry.Name())
+	}
+	return migrations, nil
+}
+
+// versionFromFilename parses the numeric prefix of a migration filename
+// such as "20231004120000_add_users.sql".
+func versionFromFilename(name string) (int64, bool) {
+	i := strings.IndexByte(name, '_')
+	if i <= 0 {
+		return 0, false
+	}
+	version, err := strconv.ParseInt(name[:i], 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return version, true
+}
+
+// mergeMigrationVersions combines the versions found on disk with the
+// registered Go migration versions into a single deduplicated, ordered run
+// list: ascending for an Up run, descending for a Down run. A version
+// present in both (a .go migration whose registration happens to share a
+// number with a stray .sql file) appears once; runMigration's isGoMigration
+// check, not this merge, is what decides which implementation actually
+// runs for it.
+func mergeMigrationVersions(sqlMigrations map[int64]string, goVersions []int64, direction bool) []int64 {
+	seen := make(map[int64]bool, len(sqlMigrations)+len(goVersions))
+	versions := make([]int64, 0, len(sqlMigrations)+len(goVersions))
+
+	add := func(v int64) {
+		if !seen[v] {
+			seen[v] = true
+			versions = append(versions, v)
+		}
+	}
+	for v := range sqlMigrations {
+		add(v)
+	}
+	for _, v := range goVersions {
+		add(v)
+	}
+
+	if direction {
+		sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	} else {
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+	}
+	return versions
+}
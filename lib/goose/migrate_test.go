@@ -0,0 +1,88 @@
+package goose
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVersionFromFilename(t *testing.T) {
+	cases := []struct {
+		name   string
+		want   int64
+		wantOk bool
+	}{
+		{name: "20231004120000_add_users.sql", want: 20231004120000, wantOk: true},
+		{name: "1_initial.sql", want: 1, wantOk: true},
+		{name: "no_leading_number.sql", wantOk: false},
+		{name: "_missing_version.sql", wantOk: false},
+		{name: "42.sql", wantOk: false},
+	}
+	for _, c := range cases {
+		got, ok := versionFromFilename(c.name)
+		if ok != c.wantOk || (ok && got != c.want) {
+			t.Errorf("versionFromFilename(%q) = (%d, %v), want (%d, %v)", c.name, got, ok, c.want, c.wantOk)
+		}
+	}
+}
+
+func TestCollectSQLMigrations(t *testing.T) {
+	dir, err := ioutil.TempDir("", "goose-migrate-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"1_initial.sql", "2_add_col.sql", "3_backfill.sql", "README.md"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("-- +goose Up\n"), 0644); err != nil {
+			t.Fatalf("WriteFile(%s): %v", name, err)
+		}
+	}
+
+	up, err := collectSQLMigrations(dir, 1, 3, true)
+	if err != nil {
+		t.Fatalf("collectSQLMigrations (up): %v", err)
+	}
+	wantUp := map[int64]string{
+		2: filepath.Join(dir, "2_add_col.sql"),
+		3: filepath.Join(dir, "3_backfill.sql"),
+	}
+	if !reflect.DeepEqual(up, wantUp) {
+		t.Errorf("up migrations = %#v, want %#v", up, wantUp)
+	}
+
+	down, err := collectSQLMigrations(dir, 3, 1, false)
+	if err != nil {
+		t.Fatalf("collectSQLMigrations (down): %v", err)
+	}
+	wantDown := map[int64]string{
+		2: filepath.Join(dir, "2_add_col.sql"),
+		3: filepath.Join(dir, "3_backfill.sql"),
+	}
+	if !reflect.DeepEqual(down, wantDown) {
+		t.Errorf("down migrations = %#v, want %#v", down, wantDown)
+	}
+}
+
+func TestMergeMigrationVersions(t *testing.T) {
+	sqlMigrations := map[int64]string{1: "1_initial.sql", 3: "3_backfill.sql"}
+
+	up := mergeMigrationVersions(sqlMigrations, []int64{2, 4}, true)
+	if want := []int64{1, 2, 3, 4}; !reflect.DeepEqual(up, want) {
+		t.Errorf("up merge = %v, want %v", up, want)
+	}
+
+	down := mergeMigrationVersions(sqlMigrations, []int64{2, 4}, false)
+	if want := []int64{4, 3, 2, 1}; !reflect.DeepEqual(down, want) {
+		t.Errorf("down merge = %v, want %v", down, want)
+	}
+
+	// A version registered as both a Go migration and a stray .sql file
+	// must appear exactly once in the merged list.
+	dup := mergeMigrationVersions(map[int64]string{2: "2_dup.sql"}, []int64{2}, true)
+	if want := []int64{2}; !reflect.DeepEqual(dup, want) {
+		t.Errorf("deduplicated merge = %v, want %v", dup, want)
+	}
+}
@@ -0,0 +1,35 @@
+package goose
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPendingGoMigrationVersions(t *testing.T) {
+	goMigrations = map[int64]GoMigration{
+		1: {}, 3: {}, 5: {}, 8: {},
+	}
+	defer func() { goMigrations = map[int64]GoMigration{} }()
+
+	cases := []struct {
+		name            string
+		current, target int64
+		want            []int64
+	}{
+		{name: "up from zero", current: 0, target: 5, want: []int64{1, 3, 5}},
+		{name: "up partial range", current: 2, target: 6, want: []int64{3, 5}},
+		{name: "up past everything registered", current: 5, target: 100, want: []int64{8}},
+		{name: "down to zero", current: 8, target: 0, want: []int64{8, 5, 3, 1}},
+		{name: "down partial range", current: 8, target: 3, want: []int64{8, 5}},
+		{name: "no movement", current: 5, target: 5, want: nil},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := pendingGoMigrationVersions(c.current, c.target)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("pendingGoMigrationVersions(%d, %d) = %v, want %v", c.current, c.target, got, c.want)
+			}
+		})
+	}
+}
@@ -3,12 +3,9 @@ package goose
 import (
 	"bufio"
 	"bytes"
-	"crypto/md5"
 	"database/sql"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -16,37 +13,64 @@ import (
 
 const sqlCmdPrefix = "-- +goose "
 
-// Checks the line to see if the line has a statement-ending semicolon
-// or if the line contains a double-dash comment.
-func endsWithSemicolon(line string) bool {
-
-	prev := ""
-	scanner := bufio.NewScanner(strings.NewReader(line))
-	scanner.Split(bufio.ScanWords)
-
-	for scanner.Scan() {
-		word := scanner.Text()
-		if strings.HasPrefix(word, "--") {
-			break
+// Split the given sql script into individual statements.
+//
+// Text outside of any '-- +goose StatementBegin' / 'StatementEnd'
+// annotation is fed straight through parseStatements, a state machine that
+// understands quoted strings, comments, dollar-quoted blocks, and nested
+// BEGIN/CASE/IF/LOOP/WHILE/REPEAT ... END blocks, so semicolons inside
+// those constructs don't end a statement early without any annotation.
+//
+// A StatementBegin/StatementEnd block is still honored exactly as before:
+// it is always emitted as a single statement, regardless of what
+// parseStatements would have done with its contents on its own. The
+// annotation is only redundant, and only then logged as deprecated, when
+// parseStatements would have produced that same single statement anyway.
+func splitSQLStatements(r io.Reader, direction bool, logger Logger) (stmts []string, err error) {
+
+	var normal bytes.Buffer
+	var annotated bytes.Buffer
+	inAnnotatedBlock := false
+
+	flushNormal := func() error {
+		if normal.Len() == 0 {
+			return nil
 		}
-		prev = word
+		parsed, err := parseStatements(normal.String(), logger)
+		if err != nil {
+			return err
+		}
+		stmts = append(stmts, parsed...)
+		normal.Reset()
+		return nil
 	}
 
-	return strings.HasSuffix(prev, ";")
-}
+	flushAnnotated := func() error {
+		text := strings.TrimSpace(annotated.String())
+		annotated.Reset()
+		if text == "" {
+			return nil
+		}
 
-// Split the given sql script into individual statements.
-//
-// The base case is to simply split on semicolons, as these
-// naturally terminate a statement.
-//
-// However, more complex cases like pl/pgsql can have semicolons
-// within a statement. For these cases, we provide the explicit annotations
-// 'StatementBegin' and 'StatementEnd' to allow the script to
-// tell us to ignore semicolons.
-func splitSQLStatements(r io.Reader, direction bool) (stmts []string) {
+		parsed, err := parseStatements(text, logger)
+		if err != nil {
+			return err
+		}
+		if len(parsed) == 1 && parsed[0] == text {
+			logger.Printf("DEPRECATED: '-- +goose StatementBegin/StatementEnd' around %q was unnecessary; "+
+				"quoted strings, dollar-quoted blocks, and BEGIN...END blocks are now detected automatically.", text)
+			stmts = append(stmts, parsed[0])
+		} else {
+			// parseStatements would have split this block (or found no
+			// terminator at all, or left text after its last terminator);
+			// the annotation forcing it into one
+			// statement is still doing real work, so keep the old
+			// forced-atomic behavior and don't warn.
+			stmts = append(stmts, text)
+		}
+		return nil
+	}
 
-	var buf bytes.Buffer
 	scanner := bufio.NewScanner(r)
 
 	// track the count of each section
@@ -54,8 +78,6 @@ func splitSQLStatements(r io.Reader, direction bool) (stmts []string) {
 	upSections := 0
 	downSections := 0
 
-	statementEnded := false
-	ignoreSemicolons := false
 	directionIsActive := false
 
 	for scanner.Scan() {
@@ -69,65 +91,63 @@ func splitSQLStatements(r io.Reader, direction bool) (stmts []string) {
 			case "Up":
 				directionIsActive = (direction == true)
 				upSections++
-				break
 
 			case "Down":
 				directionIsActive = (direction == false)
 				downSections++
-				break
 
 			case "StatementBegin":
 				if directionIsActive {
-					ignoreSemicolons = true
+					if err := flushNormal(); err != nil {
+						return nil, err
+					}
+					inAnnotatedBlock = true
 				}
-				break
 
 			case "StatementEnd":
-				if directionIsActive {
-					statementEnded = (ignoreSemicolons == true)
-					ignoreSemicolons = false
+				if directionIsActive && inAnnotatedBlock {
+					if err := flushAnnotated(); err != nil {
+						return nil, err
+					}
+					inAnnotatedBlock = false
 				}
-				break
 			}
+			continue
 		}
 
 		if !directionIsActive {
 			continue
 		}
 
-		if _, err := buf.WriteString(line + "\n"); err != nil {
-			log.Fatalf("io err: %v", err)
-		}
-
-		// Wrap up the two supported cases: 1) basic with semicolon; 2) psql statement
-		// Lines that end with semicolon that are in a statement block
-		// do not conclude statement.
-		if (!ignoreSemicolons && endsWithSemicolon(line)) || statementEnded {
-			statementEnded = false
-			stmts = append(stmts, buf.String())
-			buf.Reset()
+		if inAnnotatedBlock {
+			annotated.WriteString(line)
+			annotated.WriteByte('\n')
+		} else {
+			normal.WriteString(line)
+			normal.WriteByte('\n')
 		}
 	}
 
 	if err := scanner.Err(); err != nil {
-		log.Fatalf("scanning migration: %v", err)
+		return nil, fmt.Errorf("scanning migration: %v", err)
 	}
 
-	// diagnose likely migration script errors
-	if ignoreSemicolons {
-		log.Println("WARNING: saw '-- +goose StatementBegin' with no matching '-- +goose StatementEnd'")
+	if inAnnotatedBlock {
+		logger.Println("WARNING: saw '-- +goose StatementBegin' with no matching '-- +goose StatementEnd'")
+		if err := flushAnnotated(); err != nil {
+			return nil, err
+		}
 	}
-
-	if bufferRemaining := strings.TrimSpace(buf.String()); len(bufferRemaining) > 0 {
-		log.Printf("WARNING: Unexpected unfinished SQL query: %s. Missing a semicolon?\n", bufferRemaining)
+	if err := flushNormal(); err != nil {
+		return nil, err
 	}
 
 	if upSections == 0 && downSections == 0 {
-		log.Fatalf(`ERROR: no Up/Down annotations found, so no statements were executed.
+		return nil, fmt.Errorf(`no Up/Down annotations found, so no statements were executed.
 			See https://bitbucket.org/liamstask/goose/overview for details.`)
 	}
 
-	return
+	return stmts, nil
 }
 
 // Run a migration specified in raw SQL.
@@ -140,64 +160,50 @@ func splitSQLStatements(r io.Reader, direction bool) (stmts []string) {
 // until another direction directive is found.
 func runSQLMigration(conf *DBConf, db *sql.DB, scriptFile string, v int64, direction bool) error {
 
-	txn, err := db.Begin()
-	if err != nil {
-		log.Fatal("db.Begin:", err)
-	}
-
 	f, err := os.Open(scriptFile)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
-	data, err := ioutil.ReadFile(scriptFile)
+	defer f.Close()
+
+	stmts, err := splitSQLStatements(f, direction, loggerFor(conf))
 	if err != nil {
-		fmt.Print(err)
+		return fmt.Errorf("parsing %s: %v", filepath.Base(scriptFile), err)
 	}
 
-	md5str, err := getMD5AsString(data)
-	// find each statement, checking annotations for up/down direction
-	// and execute each of them in the current transaction.
-	// Commits the transaction if successfully applied each statement and
-	// records the version into the version table or returns an error and
-	// rolls back the transaction.
-	for _, query := range splitSQLStatements(f, direction) {
-		if _, err = txn.Exec(query); err != nil {
-			txn.Rollback()
-			log.Fatalf("FAIL %s (%v), quitting migration.", filepath.Base(scriptFile), err)
-			return err
+	if direction {
+		if err := validateChecksum(conf, db, scriptFile, stmts, v); err != nil {
+			return fmt.Errorf("checksum validation failed for %s: %v", filepath.Base(scriptFile), err)
 		}
 	}
 
-	if err = FinalizeMigration(conf, txn, direction, v, md5str); err != nil {
-		log.Fatalf("error finalizing migration %s, quitting. (%v)", filepath.Base(scriptFile), err)
-	}
-
-	return nil
-}
-
-func validateChecksum(conf *DBConf, db *sql.DB, scriptFile string, v int64) {
+	checksum := checksumStatements(stmts)
 
-	data, err := ioutil.ReadFile(scriptFile)
-	if err != nil {
-		fmt.Print(err)
-	}
-	log.Println("procesing file :", scriptFile)
-	md5str, err := getMD5AsString(data)
-	log.Println("md5 string for file:", md5str)
-	checksum, err := conf.Driver.Dialect.dbCheckSumQuery(db, v)
-	log.Println("checksum from db:", checksum)
-	if err != nil {
-		fmt.Print(err)
-	}
+	// Run the whole statement-exec-then-commit sequence through withRetry:
+	// on a RetryableDialect like CockroachDB, a contended migration is
+	// re-run from a fresh transaction instead of failing outright.
+	return withRetry(conf.Driver.Dialect, func() error {
+		txn, err := conf.Driver.Dialect.beginTx(db)
+		if err != nil {
+			return fmt.Errorf("db.Begin: %v", err)
+		}
 
-	if md5str != checksum {
-		log.Fatal("checksum mismatch for file:", scriptFile)
-	}
-}
+		// find each statement, checking annotations for up/down direction
+		// and execute each of them in the current transaction.
+		// Commits the transaction if successfully applied each statement and
+		// records the version into the version table or returns an error and
+		// rolls back the transaction.
+		for _, query := range stmts {
+			if _, err = txn.Exec(query); err != nil {
+				txn.Rollback()
+				return fmt.Errorf("FAIL %s (%v), quitting migration.", filepath.Base(scriptFile), err)
+			}
+		}
 
-func getMD5AsString(data []byte) (string, error) {
-	md5 := md5.Sum(data)
-	md5str := fmt.Sprintf("%x\n", md5)
+		if err = FinalizeMigration(conf, txn, direction, v, checksum); err != nil {
+			return fmt.Errorf("error finalizing migration %s, quitting. (%v)", filepath.Base(scriptFile), err)
+		}
 
-	return md5str, nil
+		return nil
+	})
 }
@@ -0,0 +1,87 @@
+package goose
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+type recordingLogger struct {
+	lines []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.lines = append(l.lines, strings.TrimSpace(fmt.Sprintf(format, v...)))
+}
+func (l *recordingLogger) Println(v ...interface{}) {}
+
+func (l *recordingLogger) hasDeprecationNotice() bool {
+	for _, line := range l.lines {
+		if strings.Contains(line, "DEPRECATED") {
+			return true
+		}
+	}
+	return false
+}
+
+func TestSplitSQLStatementsRedundantAnnotationWarns(t *testing.T) {
+	script := "-- +goose Up\n" +
+		"-- +goose StatementBegin\n" +
+		"CREATE TABLE foo (id int);\n" +
+		"-- +goose StatementEnd\n"
+
+	logger := &recordingLogger{}
+	stmts, err := splitSQLStatements(strings.NewReader(script), true, logger)
+	if err != nil {
+		t.Fatalf("splitSQLStatements returned error: %v", err)
+	}
+	if len(stmts) != 1 || stmts[0] != "CREATE TABLE foo (id int);" {
+		t.Fatalf("got %#v", stmts)
+	}
+	if !logger.hasDeprecationNotice() {
+		t.Error("expected a deprecation notice for a redundant StatementBegin/End block")
+	}
+}
+
+func TestSplitSQLStatementsNecessaryAnnotationIsKeptAtomic(t *testing.T) {
+	// Two bare statements inside an annotated block: parseStatements alone
+	// would split this into two statements, so the annotation is load
+	// bearing and must still force it into one, exactly like the old
+	// ignoreSemicolons behavior did.
+	script := "-- +goose Up\n" +
+		"-- +goose StatementBegin\n" +
+		"SELECT 1; SELECT 2;\n" +
+		"-- +goose StatementEnd\n"
+
+	logger := &recordingLogger{}
+	stmts, err := splitSQLStatements(strings.NewReader(script), true, logger)
+	if err != nil {
+		t.Fatalf("splitSQLStatements returned error: %v", err)
+	}
+	if len(stmts) != 1 {
+		t.Fatalf("got %d statements, want 1 (forced atomic): %#v", len(stmts), stmts)
+	}
+	if logger.hasDeprecationNotice() {
+		t.Error("did not expect a deprecation notice; the annotation was actually necessary here")
+	}
+}
+
+func TestSplitSQLStatementsNormalSectionUnaffected(t *testing.T) {
+	script := "-- +goose Up\n" +
+		"CREATE TABLE foo (id int);\n" +
+		"INSERT INTO foo VALUES (1);\n"
+
+	stmts, err := splitSQLStatements(strings.NewReader(script), true, &recordingLogger{})
+	if err != nil {
+		t.Fatalf("splitSQLStatements returned error: %v", err)
+	}
+	want := []string{"CREATE TABLE foo (id int);", "INSERT INTO foo VALUES (1);"}
+	if len(stmts) != len(want) {
+		t.Fatalf("got %#v, want %#v", stmts, want)
+	}
+	for i := range want {
+		if stmts[i] != want[i] {
+			t.Errorf("statement %d: got %q, want %q", i, stmts[i], want[i])
+		}
+	}
+}
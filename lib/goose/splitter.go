@@ -0,0 +1,307 @@
+package goose
+
+import "strings"
+
+// blockOpeners are the keywords that open a nested block inside a stored
+// routine body. Each one must be closed by either a bare END or, for
+// anything but a plain BEGIN, its own "END <keyword>" form (END CASE, END
+// IF, END LOOP, END WHILE, END REPEAT); see matchCloser. IF is also
+// MySQL's IF(cond, a, b) function, which has no matching END IF; see
+// ifIsControlFlow for how that form is told apart from the keyword.
+var blockOpeners = []string{"BEGIN", "CASE", "IF", "LOOP", "WHILE", "REPEAT"}
+
+// closerKeywords are the keywords that can legally follow "END" to close a
+// specific block kind, e.g. "END LOOP".
+var closerKeywords = []string{"CASE", "IF", "LOOP", "WHILE", "REPEAT"}
+
+// parseStatements splits a block of SQL text into individual statements. It
+// is a small state machine that understands the constructs that commonly
+// contain semicolons goose must not split on:
+//
+//   - single- and double-quoted string literals, including '' escapes
+//   - line comments (--) and block comments (/* ... */)
+//   - PostgreSQL dollar-quoted strings ($$ ... $$ or $tag$ ... $tag$)
+//   - nested BEGIN/CASE/IF/LOOP/WHILE/REPEAT ... END blocks, as used by
+//     MySQL/MSSQL stored routines and triggers
+//
+// Nesting is tracked with a stack of the keyword that opened each block,
+// rather than a flat depth counter, so a CASE or IF inside a routine body
+// closes against its own END instead of being confused for the routine's
+// outer BEGIN. A bare "BEGIN;" (no keyword after it but a semicolon) is
+// recognized as transaction control rather than a block opener, since it
+// has no matching END anywhere in the file.
+func parseStatements(sqlText string, logger Logger) (stmts []string, err error) {
+	var stmt strings.Builder
+	n := len(sqlText)
+	i := 0
+	dollarTag := ""
+	var blockStack []string
+
+	for i < n {
+		c := sqlText[i]
+
+		switch {
+		case dollarTag != "":
+			if strings.HasPrefix(sqlText[i:], dollarTag) {
+				stmt.WriteString(dollarTag)
+				i += len(dollarTag)
+				dollarTag = ""
+				continue
+			}
+			stmt.WriteByte(c)
+			i++
+
+		case c == '\'':
+			i = copyQuoted(sqlText, i, '\'', &stmt)
+
+		case c == '"':
+			i = copyQuoted(sqlText, i, '"', &stmt)
+
+		case c == '-' && i+1 < n && sqlText[i+1] == '-':
+			for i < n && sqlText[i] != '\n' {
+				stmt.WriteByte(sqlText[i])
+				i++
+			}
+
+		case c == '/' && i+1 < n && sqlText[i+1] == '*':
+			stmt.WriteString("/*")
+			i += 2
+			for i+1 < n && !(sqlText[i] == '*' && sqlText[i+1] == '/') {
+				stmt.WriteByte(sqlText[i])
+				i++
+			}
+			if i+1 < n {
+				stmt.WriteString("*/")
+				i += 2
+			} else {
+				i = n
+			}
+
+		case c == '$':
+			if tag, end, ok := dollarTagAt(sqlText, i); ok {
+				stmt.WriteString(tag)
+				dollarTag = tag
+				i = end
+			} else {
+				stmt.WriteByte(c)
+				i++
+			}
+
+		default:
+			if kw, ok := matchOpener(sqlText, i); ok {
+				stmt.WriteString(sqlText[i : i+len(kw)])
+				switch {
+				case kw == "BEGIN" && isTransactionBegin(sqlText, i+len(kw)):
+					// "BEGIN;" with nothing else is transaction control
+					// (e.g. hand-written BEGIN;/COMMIT; migrations); it has
+					// no matching END, so don't push it.
+				case kw == "IF" && !ifIsControlFlow(sqlText, i+len(kw)):
+					// IF(cond, a, b) is MySQL's IF() function, lexically
+					// identical to the control-flow keyword up to this
+					// point; it has no matching END IF, so don't push it.
+				default:
+					blockStack = append(blockStack, kw)
+				}
+				i += len(kw)
+				continue
+			}
+
+			if closeLen, ok := matchCloser(sqlText, i); ok {
+				stmt.WriteString(sqlText[i : i+closeLen])
+				if len(blockStack) > 0 {
+					blockStack = blockStack[:len(blockStack)-1]
+				}
+				i += closeLen
+				continue
+			}
+
+			if c == ';' && len(blockStack) == 0 {
+				stmt.WriteByte(c)
+				stmts = append(stmts, strings.TrimSpace(stmt.String()))
+				stmt.Reset()
+				i++
+				continue
+			}
+
+			stmt.WriteByte(c)
+			i++
+		}
+	}
+
+	if remaining := strings.TrimSpace(stmt.String()); len(remaining) > 0 {
+		logger.Printf("WARNING: Unexpected unfinished SQL query: %s. Missing a semicolon?\n", remaining)
+	}
+
+	return stmts, nil
+}
+
+// copyQuoted copies a quoted string literal starting at i (where
+// sqlText[i] == quote) into stmt, honoring the doubled-quote escape (e.g.
+// '' inside a '...' literal), and returns the index just past the closing
+// quote.
+func copyQuoted(sqlText string, i int, quote byte, stmt *strings.Builder) int {
+	n := len(sqlText)
+	stmt.WriteByte(quote)
+	i++
+	for i < n {
+		stmt.WriteByte(sqlText[i])
+		if sqlText[i] == quote {
+			i++
+			if i < n && sqlText[i] == quote {
+				stmt.WriteByte(sqlText[i])
+				i++
+				continue
+			}
+			break
+		}
+		i++
+	}
+	return i
+}
+
+// dollarTagAt reports whether sqlText[i:] begins a PostgreSQL dollar-quote
+// tag such as "$$" or "$tag$", returning the tag itself and the index just
+// past it.
+func dollarTagAt(sqlText string, i int) (tag string, end int, ok bool) {
+	n := len(sqlText)
+	j := i + 1
+	for j < n && isIdentByte(sqlText[j]) {
+		j++
+	}
+	if j < n && sqlText[j] == '$' {
+		return sqlText[i : j+1], j + 1, true
+	}
+	return "", i, false
+}
+
+// ifIsControlFlow reports whether the "IF" keyword ending at index i in
+// sqlText opens a control-flow block (IF ... THEN ... END IF) rather than
+// being a call to MySQL's IF(cond, a, b) function, which is lexically
+// identical up through the keyword itself, or part of a DDL clause such as
+// IF EXISTS / IF NOT EXISTS. If IF isn't immediately followed (modulo
+// whitespace) by '(', it is the control-flow form only when a THEN comes
+// before the next ';' (quoted literals skipped); the DDL clauses have none.
+// Otherwise, this looks past the balanced parentheses (skipping over
+// quoted literals, so a ')' inside a string doesn't close the count early):
+// the control-flow form's condition is always followed by THEN, while the
+// function call's argument list never is.
+func ifIsControlFlow(sqlText string, i int) bool {
+	n := len(sqlText)
+	j := i
+	for j < n && isSpaceByte(sqlText[j]) {
+		j++
+	}
+	if j >= n || sqlText[j] != '(' {
+		for j < n && sqlText[j] != ';' {
+			switch {
+			case sqlText[j] == '\'' || sqlText[j] == '"':
+				j = copyQuoted(sqlText, j, sqlText[j], &strings.Builder{})
+			case wordAt(sqlText, j, "THEN"):
+				return true
+			default:
+				j++
+			}
+		}
+		return false
+	}
+
+	depth := 0
+paren:
+	for j < n {
+		switch sqlText[j] {
+		case '\'':
+			j = copyQuoted(sqlText, j, '\'', &strings.Builder{})
+			continue
+		case '"':
+			j = copyQuoted(sqlText, j, '"', &strings.Builder{})
+			continue
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				j++
+				break paren
+			}
+		}
+		j++
+	}
+
+	for j < n && isSpaceByte(sqlText[j]) {
+		j++
+	}
+	return wordAt(sqlText, j, "THEN")
+}
+
+// matchOpener reports whether sqlText[i:] starts with one of blockOpeners,
+// returning the matched keyword.
+func matchOpener(sqlText string, i int) (keyword string, ok bool) {
+	for _, kw := range blockOpeners {
+		if wordAt(sqlText, i, kw) {
+			return kw, true
+		}
+	}
+	return "", false
+}
+
+// matchCloser reports whether sqlText[i:] starts with "END", optionally
+// followed by whitespace and one of closerKeywords (e.g. "END LOOP"). It
+// returns the total length of the match, covering both words when present.
+func matchCloser(sqlText string, i int) (length int, ok bool) {
+	if !wordAt(sqlText, i, "END") {
+		return 0, false
+	}
+
+	n := len(sqlText)
+	j := i + 3
+	k := j
+	for k < n && isSpaceByte(sqlText[k]) {
+		k++
+	}
+	for _, kw := range closerKeywords {
+		if wordAt(sqlText, k, kw) {
+			return (k + len(kw)) - i, true
+		}
+	}
+	return 3, true
+}
+
+// isTransactionBegin reports whether the BEGIN keyword ending at index i in
+// sqlText is immediately followed (modulo whitespace) by a semicolon, i.e.
+// it's a bare "BEGIN;" transaction-control statement rather than the start
+// of a routine body.
+func isTransactionBegin(sqlText string, i int) bool {
+	n := len(sqlText)
+	for i < n && isSpaceByte(sqlText[i]) {
+		i++
+	}
+	return i < n && sqlText[i] == ';'
+}
+
+// wordAt reports whether sqlText[i:] starts with word, matched
+// case-insensitively, as a standalone token rather than as part of a
+// longer identifier.
+func wordAt(sqlText string, i int, word string) bool {
+	n := len(sqlText)
+	if i+len(word) > n || !strings.EqualFold(sqlText[i:i+len(word)], word) {
+		return false
+	}
+	if i > 0 && isIdentByte(sqlText[i-1]) {
+		return false
+	}
+	if end := i + len(word); end < n && isIdentByte(sqlText[end]) {
+		return false
+	}
+	return true
+}
+
+func isIdentByte(b byte) bool {
+	return b == '_' ||
+		(b >= 'a' && b <= 'z') ||
+		(b >= 'A' && b <= 'Z') ||
+		(b >= '0' && b <= '9')
+}
+
+func isSpaceByte(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
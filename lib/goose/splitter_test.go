@@ -0,0 +1,255 @@
+package goose
+
+import (
+	"testing"
+)
+
+type nullLogger struct{}
+
+func (nullLogger) Printf(format string, v ...interface{}) {}
+func (nullLogger) Println(v ...interface{})               {}
+
+func TestParseStatementsBasic(t *testing.T) {
+	cases := []struct {
+		name string
+		in   string
+		want []string
+	}{
+		{
+			name: "simple statements",
+			in:   "CREATE TABLE foo (id int);\nINSERT INTO foo VALUES (1);\n",
+			want: []string{"CREATE TABLE foo (id int);", "INSERT INTO foo VALUES (1);"},
+		},
+		{
+			name: "semicolon inside single-quoted string",
+			in:   `INSERT INTO foo (name) VALUES ('a;b');`,
+			want: []string{`INSERT INTO foo (name) VALUES ('a;b');`},
+		},
+		{
+			name: "escaped quote inside single-quoted string",
+			in:   `INSERT INTO foo (name) VALUES ('a''b;c');`,
+			want: []string{`INSERT INTO foo (name) VALUES ('a''b;c');`},
+		},
+		{
+			name: "semicolon inside double-quoted identifier",
+			in:   `SELECT 1 AS "weird;name";`,
+			want: []string{`SELECT 1 AS "weird;name";`},
+		},
+		{
+			name: "semicolon inside line comment",
+			in:   "SELECT 1; -- a comment; with a semicolon\nSELECT 2;",
+			want: []string{"SELECT 1;", "-- a comment; with a semicolon\nSELECT 2;"},
+		},
+		{
+			name: "semicolon inside block comment",
+			in:   "SELECT 1 /* a; b */ ;\n",
+			want: []string{"SELECT 1 /* a; b */ ;"},
+		},
+		{
+			name: "postgres dollar-quoted function body",
+			in: `CREATE FUNCTION f() RETURNS int AS $$
+BEGIN
+    RETURN 1;
+END;
+$$ LANGUAGE plpgsql;`,
+			want: []string{
+				"CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n    RETURN 1;\nEND;\n$$ LANGUAGE plpgsql;",
+			},
+		},
+		{
+			name: "postgres tagged dollar-quoted string",
+			in:   `SELECT $tag$a;b$tag$;`,
+			want: []string{`SELECT $tag$a;b$tag$;`},
+		},
+		{
+			name: "mysql-style BEGIN...END trigger body",
+			in: `CREATE TRIGGER trg BEFORE INSERT ON foo
+FOR EACH ROW
+BEGIN
+    SET NEW.created_at = NOW();
+    SET NEW.updated_at = NOW();
+END;`,
+			want: []string{
+				"CREATE TRIGGER trg BEFORE INSERT ON foo\nFOR EACH ROW\nBEGIN\n    SET NEW.created_at = NOW();\n    SET NEW.updated_at = NOW();\nEND;",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := parseStatements(c.in, nullLogger{})
+			if err != nil {
+				t.Fatalf("parseStatements returned error: %v", err)
+			}
+			if len(got) != len(c.want) {
+				t.Fatalf("got %d statements, want %d\ngot:  %#v\nwant: %#v", len(got), len(c.want), got, c.want)
+			}
+			for i := range got {
+				if got[i] != c.want[i] {
+					t.Errorf("statement %d mismatch:\ngot:  %q\nwant: %q", i, got[i], c.want[i])
+				}
+			}
+		})
+	}
+}
+
+// TestParseStatementsCaseEndWithoutBegin exercises a CASE expression with
+// no enclosing BEGIN: CASE is its own stack entry and closes against its
+// own END, rather than being confused for (or corrupting) an outer block.
+func TestParseStatementsCaseEndWithoutBegin(t *testing.T) {
+	in := "SELECT CASE WHEN 1=1 THEN 'a' ELSE 'b' END;\nSELECT 2;"
+	got, err := parseStatements(in, nullLogger{})
+	if err != nil {
+		t.Fatalf("parseStatements returned error: %v", err)
+	}
+	want := []string{"SELECT CASE WHEN 1=1 THEN 'a' ELSE 'b' END;", "SELECT 2;"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d statements, want %d: %#v", len(got), len(want), got)
+	}
+}
+
+// TestParseStatementsNestedCaseInsideRoutineBody is the scenario a flat
+// BEGIN/END depth counter gets wrong: a CASE...END CASE inside a routine
+// body must close against its own CASE, not be mistaken for the routine's
+// outer BEGIN, so the whole routine still comes back as one statement.
+func TestParseStatementsNestedCaseInsideRoutineBody(t *testing.T) {
+	in := "CREATE PROCEDURE foo() BEGIN CASE x WHEN 1 THEN SELECT 1; END CASE; SELECT 2; END;"
+	got, err := parseStatements(in, nullLogger{})
+	if err != nil {
+		t.Fatalf("parseStatements returned error: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d statements, want 1: %#v", len(got), got)
+	}
+	if got[0] != in {
+		t.Errorf("got %q, want %q", got[0], in)
+	}
+}
+
+// TestParseStatementsNestedIfInsideRoutineBody is the IF...END IF analogue
+// of the CASE case above.
+func TestParseStatementsNestedIfInsideRoutineBody(t *testing.T) {
+	in := "CREATE PROCEDURE foo() BEGIN IF x THEN SELECT 1; END IF; SELECT 2; END;"
+	got, err := parseStatements(in, nullLogger{})
+	if err != nil {
+		t.Fatalf("parseStatements returned error: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d statements, want 1: %#v", len(got), got)
+	}
+}
+
+// TestParseStatementsIfFunctionCallIsNotABlockOpener guards against a
+// regression where MySQL's ordinary IF(cond, a, b) function call, which is
+// lexically identical to the control-flow IF keyword up through "IF(", got
+// pushed onto blockStack as if it opened a routine body. Since an IF()
+// function call has no matching END IF anywhere in the file, that left
+// every statement from the IF(...) call onward silently dropped instead of
+// returned.
+func TestParseStatementsIfFunctionCallIsNotABlockOpener(t *testing.T) {
+	in := "SELECT IF(a>b, a, b) FROM t;\nSELECT 2;"
+	got, err := parseStatements(in, nullLogger{})
+	if err != nil {
+		t.Fatalf("parseStatements returned error: %v", err)
+	}
+	want := []string{"SELECT IF(a>b, a, b) FROM t;", "SELECT 2;"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d statements, want %d: %#v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("statement %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+// TestParseStatementsIfFunctionCallInsideRoutineBody covers the same
+// IF(cond, a, b) function call appearing inside a routine body alongside a
+// real control-flow IF...END IF: the function call must not consume the
+// outer BEGIN's matching END, and the control-flow IF must still close
+// against its own END IF.
+func TestParseStatementsIfFunctionCallInsideRoutineBody(t *testing.T) {
+	in := "CREATE PROCEDURE foo() BEGIN SET x = IF(a>b, a, b); IF x THEN SELECT 1; END IF; SELECT 2; END;"
+	got, err := parseStatements(in, nullLogger{})
+	if err != nil {
+		t.Fatalf("parseStatements returned error: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d statements, want 1: %#v", len(got), got)
+	}
+	if got[0] != in {
+		t.Errorf("got %q, want %q", got[0], in)
+	}
+}
+
+// TestParseStatementsIfConditionWithParenInStringLiteral guards against
+// ifIsControlFlow's paren-balancing being thrown off by a ')' inside a
+// quoted string literal within the IF condition, which would otherwise
+// make it think the condition's parentheses closed early, miss the
+// trailing THEN, and misclassify this control-flow IF as an IF() function
+// call with no matching END IF.
+func TestParseStatementsIfConditionWithParenInStringLiteral(t *testing.T) {
+	in := "CREATE PROCEDURE foo() BEGIN IF (x = ')') THEN SELECT 1; END IF; SELECT 2; END;"
+	got, err := parseStatements(in, nullLogger{})
+	if err != nil {
+		t.Fatalf("parseStatements returned error: %v", err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d statements, want 1: %#v", len(got), got)
+	}
+	if got[0] != in {
+		t.Errorf("got %q, want %q", got[0], in)
+	}
+}
+
+// TestParseStatementsTransactionBegin ensures a bare "BEGIN;" transaction
+// control statement (no matching END anywhere in the file) is treated as
+// an ordinary statement rather than an unterminated block opener, which
+// would otherwise swallow the rest of the file into one never-terminated
+// buffer.
+func TestParseStatementsTransactionBegin(t *testing.T) {
+	in := "BEGIN;\nCREATE TABLE foo (id int);\nCOMMIT;"
+	got, err := parseStatements(in, nullLogger{})
+	if err != nil {
+		t.Fatalf("parseStatements returned error: %v", err)
+	}
+	want := []string{"BEGIN;", "CREATE TABLE foo (id int);", "COMMIT;"}
+	if len(got) != len(want) {
+		t.Fatalf("got %d statements, want %d: %#v", len(got), len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("statement %d: got %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+// FuzzParseStatements checks that parseStatements never panics, never
+// returns an error, and never produces an empty statement, regardless of
+// what quoting/comment/BEGIN-END nesting the input throws at it.
+func FuzzParseStatements(f *testing.F) {
+	seeds := []string{
+		"CREATE TABLE foo (id int);",
+		"INSERT INTO foo VALUES ('a;b');",
+		"SELECT $$a;b$$;",
+		"CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql;",
+		"-- +goose StatementBegin\nCREATE TRIGGER trg BEFORE INSERT ON foo FOR EACH ROW BEGIN SET NEW.x = 1; END;\n-- +goose StatementEnd",
+		"SELECT 'unterminated",
+		"$tag$ $other$ $$",
+	}
+	for _, s := range seeds {
+		f.Add(s)
+	}
+
+	f.Fuzz(func(t *testing.T, in string) {
+		stmts, err := parseStatements(in, nullLogger{})
+		if err != nil {
+			t.Fatalf("parseStatements returned an error, it should only ever warn: %v", err)
+		}
+		for _, stmt := range stmts {
+			if stmt == "" {
+				t.Fatalf("parseStatements produced an empty statement for input %q", in)
+			}
+		}
+	})
+}